@@ -0,0 +1,114 @@
+package block
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// accessJournalFileName is the name of the small on-disk journal that
+// persists last-access times across restarts, so the LRU sweep policy
+// doesn't have to fall back to mtime immediately after a process restart.
+const accessJournalFileName = "access-journal.json"
+
+// accessTracker records the last-access time of cache entries, keyed by the
+// same name used for the corresponding cache file (without cachedSuffix).
+// It's consulted by sweepDirectory to evict least-recently-used entries
+// first, rather than relying on filesystem ModTime.
+type accessTracker struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newAccessTracker() *accessTracker {
+	return &accessTracker{last: map[string]time.Time{}}
+}
+
+func (t *accessTracker) recordAccess(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.last[key] = time.Now()
+}
+
+func (t *accessTracker) forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.last, key)
+}
+
+// accessTime returns the tracked last-access time for key, falling back to
+// fallback (typically the file's ModTime) when nothing has been recorded
+// for key, neither in this process nor in the journal loaded at startup.
+func (t *accessTracker) accessTime(key string, fallback time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if at, ok := t.last[key]; ok {
+		return at
+	}
+
+	return fallback
+}
+
+func (t *accessTracker) snapshot() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := make(map[string]time.Time, len(t.last))
+	for k, v := range t.last {
+		s[k] = v
+	}
+
+	return s
+}
+
+func (t *accessTracker) load(entries map[string]time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k, v := range entries {
+		t.last[k] = v
+	}
+}
+
+// persistAccessJournal writes the current access-time snapshot to a journal
+// file in dir, so it can be reloaded on the next startup.
+func (c *diskBlockCache) persistAccessJournal() {
+	data, err := json.Marshal(c.accessTracker.snapshot())
+	if err != nil {
+		log.Printf("warning: unable to marshal access journal: %v", err)
+		return
+	}
+
+	if err := c.writeFileAtomic(c.journalFileName(), data); err != nil {
+		log.Printf("warning: unable to persist access journal: %v", err)
+	}
+}
+
+// loadAccessJournal reads back a previously-persisted access journal, if
+// one exists. Absence or corruption is not an error - the tracker simply
+// starts cold and the sweeper falls back to file ModTime.
+func (c *diskBlockCache) loadAccessJournal() {
+	data, err := ioutil.ReadFile(c.journalFileName())
+	if err != nil {
+		return
+	}
+
+	var entries map[string]time.Time
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("warning: corrupt access journal, ignoring: %v", err)
+		return
+	}
+
+	c.accessTracker.load(entries)
+}
+
+func (c *diskBlockCache) journalFileName() string {
+	return filepath.Join(c.directory, accessJournalFileName)
+}
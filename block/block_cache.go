@@ -0,0 +1,311 @@
+package block
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kopia/kopia/internal/workshare"
+	"github.com/kopia/kopia/storage"
+)
+
+// BlockCache is implemented by every cache backend driver. Callers in
+// block/ should depend on this interface rather than on diskBlockCache
+// directly, so that tests can inject a fake and a repo can pick its driver
+// (and driver options) via a DSN-style configuration string.
+type BlockCache interface {
+	// Get returns the contents of virtualBlockID, transparently fetching
+	// and caching it from physicalBlockID in the backing storage.Storage on
+	// a cache miss.
+	Get(virtualBlockID, physicalBlockID string, offset, length int64) ([]byte, error)
+
+	// Put writes blockID to the backing storage.Storage (if any) and caches
+	// its contents.
+	Put(blockID string, data []byte) error
+
+	// Delete invalidates any cached copy of key.
+	Delete(key string) error
+
+	// List returns the list of index blocks, active-only unless full is set.
+	List(full bool) ([]Info, error)
+
+	Close() error
+
+	// Metrics returns a point-in-time snapshot of this driver's hit/miss/
+	// eviction and compression counters.
+	Metrics() CacheMetrics
+}
+
+// CacheMetrics is a point-in-time snapshot of a BlockCache driver's counters,
+// as returned by BlockCache.Metrics. A driver that doesn't track a given
+// counter (e.g. a memoryCacheDriver never compresses) leaves it at zero.
+type CacheMetrics struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+
+	UncompressedBytes int64
+	CompressedBytes   int64
+}
+
+// NewBlockCache builds a BlockCache driver from a DSN-style configuration
+// string, e.g.:
+//
+//	type=fs,dir=/var/kopia,size=10GB
+//	type=fs,dir=/var/kopia,size=10GB,memcache=256MB,compression=default,maxitems=100000,minfree=1GB,workers=4
+//	type=memory,size=512MB
+//	type=tiered,l1=memory:256MB,l2=fs:/var/kopia:10GB
+//
+// st is the backing storage.Storage consulted on cache misses and written
+// through to by Put; hmacSecret authenticates cache entries written to
+// disk by the fs driver. The fs driver's "compression", "maxitems",
+// "minfree", and "workers" parameters correspond to SetCompressionPolicy,
+// SetEvictionLimits, and SetWorkPool, which are otherwise only reachable by
+// constructing a diskBlockCache directly.
+func NewBlockCache(dsn string, st storage.Storage, hmacSecret []byte) (BlockCache, error) {
+	params, err := parseKVDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDriver(params["type"], params, st, hmacSecret)
+}
+
+func newDriver(driverType string, params map[string]string, st storage.Storage, hmacSecret []byte) (BlockCache, error) {
+	switch driverType {
+	case "fs":
+		return newFSDriver(params, st, hmacSecret, -1)
+
+	case "memory":
+		return newMemoryDriver(params, st)
+
+	case "tiered":
+		return newTieredDriver(params, st, hmacSecret)
+
+	case "":
+		return nil, fmt.Errorf("missing cache type in DSN (expected type=fs|memory|tiered)")
+
+	default:
+		return nil, fmt.Errorf("unknown cache type %q", driverType)
+	}
+}
+
+// newFSDriver builds the "fs" BlockCache driver. memDefault is the in-process
+// L1 memory cache size to use absent a "memcache" DSN override (0 disables
+// it, e.g. for the l2 tier of a "tiered" cache that already has its own l1).
+func newFSDriver(params map[string]string, st storage.Storage, hmacSecret []byte, memDefault int64) (BlockCache, error) {
+	dir := params["dir"]
+	if dir == "" {
+		return nil, fmt.Errorf("fs cache requires a 'dir' parameter")
+	}
+
+	size, err := parseByteSize(params["size"])
+	if err != nil {
+		return nil, err
+	}
+
+	memSize := memDefault
+	if v := params["memcache"]; v != "" {
+		memSize, err = parseByteSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memcache size: %v", err)
+		}
+	}
+
+	c := newDiskBlockCache(st, dir, size, defaultListCacheDuration, hmacSecret, memSize)
+
+	if err := applyFSDriverParams(c, params); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// applyFSDriverParams wires the compression, eviction-limit, and sweep/
+// prefetch parallelism knobs built by chunk0-2/chunk0-3/chunk0-4 onto c, from
+// DSN parameters that would otherwise only be reachable by constructing a
+// diskBlockCache directly: "compression" (none|fast|default), "maxitems",
+// "minfree" (byte size), and "workers" (worker pool size).
+func applyFSDriverParams(c *diskBlockCache, params map[string]string) error {
+	if v := params["compression"]; v != "" {
+		policy, err := parseCompressionPolicy(v)
+		if err != nil {
+			return err
+		}
+
+		c.SetCompressionPolicy(policy, 0)
+	}
+
+	maxItems, err := parseOptionalInt(params["maxitems"])
+	if err != nil {
+		return fmt.Errorf("invalid maxitems %q: %v", params["maxitems"], err)
+	}
+
+	var minFree int64
+	if v := params["minfree"]; v != "" {
+		minFree, err = parseByteSize(v)
+		if err != nil {
+			return fmt.Errorf("invalid minfree size: %v", err)
+		}
+	}
+
+	if maxItems != 0 || minFree != 0 {
+		c.SetEvictionLimits(maxItems, minFree)
+	}
+
+	if v := params["workers"]; v != "" {
+		workers, err := parseOptionalInt(v)
+		if err != nil {
+			return fmt.Errorf("invalid workers %q: %v", v, err)
+		}
+
+		if workers > 0 {
+			c.workPool = workshare.NewPool(int(workers))
+			c.ownsWorkPool = true
+		}
+	}
+
+	return nil
+}
+
+// parseCompressionPolicy maps a DSN "compression" value to a
+// CompressionPolicy.
+func parseCompressionPolicy(s string) (CompressionPolicy, error) {
+	switch s {
+	case "none":
+		return CompressionNone, nil
+
+	case "fast":
+		return CompressionZstdFast, nil
+
+	case "default":
+		return CompressionZstdDefault, nil
+
+	default:
+		return CompressionNone, fmt.Errorf("unknown compression policy %q (expected none|fast|default)", s)
+	}
+}
+
+// parseOptionalInt parses s as a plain integer, returning 0 if s is empty.
+func parseOptionalInt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func newMemoryDriver(params map[string]string, st storage.Storage) (BlockCache, error) {
+	size, err := parseByteSize(params["size"])
+	if err != nil {
+		return nil, err
+	}
+
+	return newMemoryCacheDriver(st, size), nil
+}
+
+func newTieredDriver(params map[string]string, st storage.Storage, hmacSecret []byte) (BlockCache, error) {
+	l1, err := newSimpleDriver(params["l1"], nil, hmacSecret, -1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid l1 cache spec %q: %v", params["l1"], err)
+	}
+
+	// l2's own L1 memory cache defaults to disabled: l1 above already serves
+	// that purpose, and leaving it enabled would add a second, uncontrollable
+	// memory tier underneath the one the user configured.
+	l2, err := newSimpleDriver(params["l2"], st, hmacSecret, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid l2 cache spec %q: %v", params["l2"], err)
+	}
+
+	return newTieredBlockCache(l1, l2), nil
+}
+
+// newSimpleDriver builds a driver from the compact colon-separated spec used
+// for the l1/l2 tiers of a tiered cache, e.g. "memory:256MB" or
+// "fs:/var/kopia:10GB". st is nil for a pure cache tier (typically l1) with
+// no storage.Storage of its own to fall back to or write through to.
+// fsMemDefault is passed through to newFSDriver for the "fs:" case.
+func newSimpleDriver(spec string, st storage.Storage, hmacSecret []byte, fsMemDefault int64) (BlockCache, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("missing cache spec")
+	}
+
+	parts := strings.Split(spec, ":")
+
+	switch parts[0] {
+	case "memory":
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected memory:<size>")
+		}
+
+		return newMemoryDriver(map[string]string{"size": parts[1]}, st)
+
+	case "fs":
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("expected fs:<dir>:<size>")
+		}
+
+		return newFSDriver(map[string]string{"dir": parts[1], "size": parts[2]}, st, hmacSecret, fsMemDefault)
+
+	default:
+		return nil, fmt.Errorf("unknown cache type %q", parts[0])
+	}
+}
+
+// parseKVDSN parses a comma-separated "key=value,key2=value2" DSN into a
+// map. Values themselves may contain colons (as in the l1/l2 tiered cache
+// specs) but not commas.
+func parseKVDSN(dsn string) (map[string]string, error) {
+	params := map[string]string{}
+
+	for _, kv := range strings.Split(dsn, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid DSN parameter %q, expected key=value", kv)
+		}
+
+		params[parts[0]] = parts[1]
+	}
+
+	return params, nil
+}
+
+// parseByteSize parses a size like "64MB", "512MB", "10GB", or a plain
+// number of bytes, using binary (1024-based) multiples.
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("missing size")
+	}
+
+	multiplier := int64(1)
+
+	for suffix, m := range map[string]int64{
+		"KB": 1 << 10,
+		"MB": 1 << 20,
+		"GB": 1 << 30,
+	} {
+		if strings.HasSuffix(s, suffix) {
+			multiplier = m
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+
+	return n * multiplier, nil
+}
+
+// defaultListCacheDuration is used by the fs driver when built through
+// NewBlockCache, where no separate list-cache TTL is configurable via DSN.
+const defaultListCacheDuration = 10 * time.Minute
@@ -0,0 +1,126 @@
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{
+		"0":    0,
+		"1024": 1024,
+		"1KB":  1 << 10,
+		"64MB": 64 << 20,
+		"10GB": 10 << 30,
+	}
+
+	for in, want := range cases {
+		got, err := parseByteSize(in)
+		if err != nil {
+			t.Errorf("parseByteSize(%q) returned error: %v", in, err)
+			continue
+		}
+
+		if got != want {
+			t.Errorf("parseByteSize(%q) = %v, want %v", in, got, want)
+		}
+	}
+
+	if _, err := parseByteSize(""); err == nil {
+		t.Error("expected error for empty size")
+	}
+}
+
+func TestParseKVDSN(t *testing.T) {
+	params, err := parseKVDSN("type=tiered,l1=memory:256MB,l2=fs:/var/kopia:10GB")
+	if err != nil {
+		t.Fatalf("parseKVDSN failed: %v", err)
+	}
+
+	want := map[string]string{
+		"type": "tiered",
+		"l1":   "memory:256MB",
+		"l2":   "fs:/var/kopia:10GB",
+	}
+
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("params[%q] = %q, want %q", k, params[k], v)
+		}
+	}
+
+	if _, err := parseKVDSN("type"); err == nil {
+		t.Error("expected error for DSN parameter missing '='")
+	}
+}
+
+func TestNewBlockCache_FSDriverHonorsMemcacheDSNParam(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewBlockCache("type=fs,dir="+dir+",size=10MB,memcache=0", nil, nil)
+	if err != nil {
+		t.Fatalf("NewBlockCache failed: %v", err)
+	}
+	defer c.Close()
+
+	dc, ok := c.(*diskBlockCache)
+	if !ok {
+		t.Fatalf("expected *diskBlockCache, got %T", c)
+	}
+
+	if dc.memCache.maxBytes != 0 {
+		t.Errorf("expected memcache=0 to disable the L1 memory cache, got maxBytes=%v", dc.memCache.maxBytes)
+	}
+}
+
+func TestNewBlockCache_FSDriverHonorsCompressionEvictionAndWorkerDSNParams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewBlockCache("type=fs,dir="+dir+",size=10MB,compression=fast,maxitems=42,minfree=1MB,workers=3", nil, nil)
+	if err != nil {
+		t.Fatalf("NewBlockCache failed: %v", err)
+	}
+	defer c.Close()
+
+	dc, ok := c.(*diskBlockCache)
+	if !ok {
+		t.Fatalf("expected *diskBlockCache, got %T", c)
+	}
+
+	if dc.compressionPolicy != CompressionZstdFast {
+		t.Errorf("compressionPolicy = %v, want CompressionZstdFast", dc.compressionPolicy)
+	}
+
+	if dc.maxItems != 42 {
+		t.Errorf("maxItems = %v, want 42", dc.maxItems)
+	}
+
+	if dc.minFreeDiskBytes != 1<<20 {
+		t.Errorf("minFreeDiskBytes = %v, want %v", dc.minFreeDiskBytes, 1<<20)
+	}
+
+	if dc.workPool == nil || !dc.ownsWorkPool {
+		t.Error("expected workers=3 to install an owned work pool")
+	}
+}
+
+func TestNewBlockCache_FSDriverRejectsUnknownCompressionPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewBlockCache("type=fs,dir="+dir+",size=10MB,compression=lzma", nil, nil); err == nil {
+		t.Error("expected an error for an unknown compression policy")
+	}
+}
@@ -0,0 +1,210 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// chunkManifestMagic distinguishes a manifest file (the content-defined
+// chunking of a large cache entry) from a regular single-file cache entry,
+// which either carries cacheFormatMagic or is raw legacy data.
+const chunkManifestMagic = "KPCMF1"
+
+// chunkedCacheThresholdBytes is the minimum size of an encoded cache payload
+// before diskBlockCache stores it as content-defined chunks instead of a
+// single file.
+const chunkedCacheThresholdBytes = 1 << 20 // 1 MiB
+
+const chunkFilePrefix = "chunk-"
+
+type chunkManifestEntry struct {
+	Hash   string `json:"hash"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+type chunkManifest struct {
+	TotalLength int64                `json:"totalLength"`
+	Chunks      []chunkManifestEntry `json:"chunks"`
+}
+
+func chunkHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *diskBlockCache) chunkFileName(hash string) string {
+	return filepath.Join(c.directory, chunkFilePrefix+hash+cachedSuffix)
+}
+
+// writeCacheBlockFile persists encoded (the result of appendHMAC-ready,
+// possibly-compressed cache payload bytes) under fn, splitting it into
+// content-defined chunks when it's large enough that doing so saves
+// rewriting the whole entry whenever it changes slightly.
+func (c *diskBlockCache) writeCacheBlockFile(fn string, encoded []byte) error {
+	if len(encoded) < chunkedCacheThresholdBytes {
+		return c.writeFileAtomic(fn, c.appendHMAC(encoded))
+	}
+
+	// gcOrphanedChunks (run by sweepDirectory, which holds c.mu for the whole
+	// sweep) deletes any chunk file not referenced by a manifest in its
+	// directory snapshot. Without holding the same lock here, a sweep
+	// running concurrently with this write could take its snapshot before
+	// this manifest is written, decide one of the chunks below is orphaned
+	// because nothing yet references it, and delete a chunk the dedup check
+	// just found already on disk - out from under the manifest being built
+	// to reference it.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	manifest := chunkManifest{TotalLength: int64(len(encoded))}
+
+	var offset int64
+	for _, chunk := range splitContentDefinedChunks(encoded) {
+		hash := chunkHash(chunk)
+		manifest.Chunks = append(manifest.Chunks, chunkManifestEntry{
+			Hash:   hash,
+			Offset: offset,
+			Length: int64(len(chunk)),
+		})
+		offset += int64(len(chunk))
+
+		chunkFn := c.chunkFileName(hash)
+		if _, err := os.Stat(chunkFn); err == nil {
+			// identical chunk already cached under its content hash - dedup.
+			continue
+		}
+
+		if err := c.writeFileAtomic(chunkFn, c.appendHMAC(chunk)); err != nil {
+			return fmt.Errorf("unable to write chunk %v: %v", hash, err)
+		}
+	}
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return c.writeFileAtomic(fn, c.appendHMAC(append([]byte(chunkManifestMagic), manifestData...)))
+}
+
+// readCacheBlockFile reads and HMAC-verifies fn, transparently reassembling
+// it from content-defined chunks if it was written as a manifest. The
+// returned bytes are the encoded cache payload, as would have been produced
+// by encodeCacheBlock - callers still need to decodeCacheBlock them.
+func (c *diskBlockCache) readCacheBlockFile(fn string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.verifyHMAC(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(chunkManifestMagic) || string(data[:len(chunkManifestMagic)]) != chunkManifestMagic {
+		return data, nil
+	}
+
+	var manifest chunkManifest
+	if err := json.Unmarshal(data[len(chunkManifestMagic):], &manifest); err != nil {
+		return nil, fmt.Errorf("corrupt chunk manifest %v: %v", fn, err)
+	}
+
+	return c.reassembleChunks(manifest)
+}
+
+// readManifestIfPresent reads and HMAC-verifies fn and, if it's a chunk
+// manifest, unmarshals and returns it. ok is false when fn isn't a valid,
+// HMAC-verified manifest (e.g. it's a regular single-file cache entry, or it
+// failed verification) - fn should simply be left alone by the caller in
+// that case.
+func (c *diskBlockCache) readManifestIfPresent(fn string) (manifest chunkManifest, ok bool) {
+	raw, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return chunkManifest{}, false
+	}
+
+	data, err := c.verifyHMAC(raw)
+	if err != nil {
+		return chunkManifest{}, false
+	}
+
+	if len(data) < len(chunkManifestMagic) || string(data[:len(chunkManifestMagic)]) != chunkManifestMagic {
+		return chunkManifest{}, false
+	}
+
+	if err := json.Unmarshal(data[len(chunkManifestMagic):], &manifest); err != nil {
+		return chunkManifest{}, false
+	}
+
+	return manifest, true
+}
+
+// manifestChunkHashes returns the content hashes referenced by the manifest
+// stored at fn, for use by gcOrphanedChunks when deciding which chunk files
+// are still live.
+func (c *diskBlockCache) manifestChunkHashes(fn string) (hashes []string, ok bool) {
+	manifest, ok := c.readManifestIfPresent(fn)
+	if !ok {
+		return nil, false
+	}
+
+	for _, entry := range manifest.Chunks {
+		hashes = append(hashes, entry.Hash)
+	}
+
+	return hashes, true
+}
+
+// manifestChunkBytes returns the total size of the chunk files referenced by
+// manifest, for use by verifyCacheFileOnDisk when computing the disk
+// footprint sweepDirectory should charge against its size budget for a chunk
+// manifest: the manifest file itself is tiny, but the chunks it references
+// are where the actual cached bytes live. Takes an already-parsed manifest
+// rather than a file name so callers that already read it (verifyCacheFileOnDisk
+// always does, to HMAC-verify it) don't pay for a second read of the same file.
+func manifestChunkBytes(manifest chunkManifest) int64 {
+	var total int64
+	for _, entry := range manifest.Chunks {
+		total += entry.Length
+	}
+
+	return total
+}
+
+func (c *diskBlockCache) reassembleChunks(manifest chunkManifest) ([]byte, error) {
+	out := make([]byte, 0, manifest.TotalLength)
+
+	for _, entry := range manifest.Chunks {
+		chunkFn := c.chunkFileName(entry.Hash)
+
+		raw, err := ioutil.ReadFile(chunkFn)
+		if err != nil {
+			return nil, fmt.Errorf("missing chunk %v: %v", entry.Hash, err)
+		}
+
+		chunk, err := c.verifyHMAC(raw)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt chunk %v: %v", entry.Hash, err)
+		}
+
+		if chunkHash(chunk) != entry.Hash {
+			return nil, fmt.Errorf("chunk %v content hash mismatch", entry.Hash)
+		}
+
+		out = append(out, chunk...)
+	}
+
+	if int64(len(out)) != manifest.TotalLength {
+		return nil, fmt.Errorf("reassembled chunk length %v does not match manifest length %v", len(out), manifest.TotalLength)
+	}
+
+	return out, nil
+}
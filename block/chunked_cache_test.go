@@ -0,0 +1,44 @@
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGcOrphanedChunks_RemovesUnreferencedChunksOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newDiskBlockCache(nil, dir, 0, defaultListCacheDuration, nil, 0)
+
+	encoded := make([]byte, chunkedCacheThresholdBytes+1)
+	for i := range encoded {
+		encoded[i] = byte(i)
+	}
+
+	if err := c.writeCacheBlockFile(c.cachedItemName("big-block"), encoded); err != nil {
+		t.Fatalf("writeCacheBlockFile failed: %v", err)
+	}
+
+	// an orphaned chunk file that's not referenced by any surviving manifest.
+	orphanFn := c.chunkFileName("deadbeef")
+	if err := c.writeFileAtomic(orphanFn, c.appendHMAC([]byte("orphan"))); err != nil {
+		t.Fatalf("unable to write orphan chunk: %v", err)
+	}
+
+	manifestName := filepath.Base(c.cachedItemName("big-block"))
+	c.gcOrphanedChunks([]string{manifestName})
+
+	if _, err := os.Stat(orphanFn); !os.IsNotExist(err) {
+		t.Fatalf("expected orphaned chunk to be removed, stat err = %v", err)
+	}
+
+	if _, err := c.readCacheBlockFile(c.cachedItemName("big-block")); err != nil {
+		t.Fatalf("expected chunks referenced by a retained manifest to survive: %v", err)
+	}
+}
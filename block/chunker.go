@@ -0,0 +1,85 @@
+package block
+
+const (
+	// chunkWindowSize is the size, in bytes, of the sliding window the
+	// rolling hash is computed over.
+	chunkWindowSize = 64
+
+	// chunkMaskBits sets the boundary probability (one in 2^chunkMaskBits
+	// bytes) once a chunk has reached chunkMinSizeBytes, so chunks produced by
+	// splitContentDefinedChunks average roughly chunkMinSizeBytes +
+	// 2^chunkMaskBits bytes.
+	chunkMaskBits = 18 // 256 KiB past the minimum, about 512 KiB on average
+
+	chunkMinSizeBytes = 256 << 10 // 256 KiB
+	chunkMaxSizeBytes = 4 << 20   // 4 MiB
+)
+
+// buzhashTable is a deterministic (not randomly seeded at runtime) table of
+// per-byte hash contributions, so that chunk boundaries are reproducible
+// across processes and platforms.
+var buzhashTable = generateBuzhashTable()
+
+func generateBuzhashTable() [256]uint32 {
+	var table [256]uint32
+
+	h := uint32(0x9e3779b9)
+	for i := range table {
+		h ^= h << 13
+		h ^= h >> 17
+		h ^= h << 5
+		table[i] = h
+	}
+
+	return table
+}
+
+func rotateLeft32(x uint32, n uint) uint32 {
+	return x<<n | x>>(32-n)
+}
+
+// splitContentDefinedChunks splits data into content-defined chunks using a
+// buzhash rolling hash over a sliding window of chunkWindowSize bytes. A
+// chunk boundary is declared whenever the low chunkMaskBits bits of the
+// rolling hash are all zero, subject to chunkMinSizeBytes/chunkMaxSizeBytes
+// bounds. Because the boundary only depends on a local window of content,
+// inserting or removing bytes elsewhere in data doesn't shift most chunk
+// boundaries, so slowly-mutating payloads mostly produce the same chunks
+// (and the same content-addressed filenames) from one write to the next.
+func splitContentDefinedChunks(data []byte) [][]byte {
+	if len(data) <= chunkMinSizeBytes {
+		return [][]byte{data}
+	}
+
+	const mask = uint32(1)<<chunkMaskBits - 1
+
+	var chunks [][]byte
+	var h uint32
+	start := 0
+
+	for i, b := range data {
+		h = rotateLeft32(h, 1) ^ buzhashTable[b]
+
+		if i >= chunkWindowSize {
+			outByte := data[i-chunkWindowSize]
+			h ^= rotateLeft32(buzhashTable[outByte], uint(chunkWindowSize)%32)
+		}
+
+		chunkLen := i - start + 1
+		if chunkLen < chunkMinSizeBytes {
+			continue
+		}
+
+		if chunkLen >= chunkMaxSizeBytes || h&mask == 0 {
+			chunks = append(chunks, data[start:i+1])
+			start = i + 1
+			h = 0
+		}
+	}
+
+	if start < len(data) {
+		chunks = append(chunks, data[start:])
+	}
+
+	return chunks
+}
@@ -0,0 +1,66 @@
+package block
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestSplitContentDefinedChunks_SmallInputIsOneChunk(t *testing.T) {
+	data := make([]byte, chunkMinSizeBytes)
+
+	chunks := splitContentDefinedChunks(data)
+	if len(chunks) != 1 {
+		t.Fatalf("expected 1 chunk for input at the minimum size, got %v", len(chunks))
+	}
+}
+
+func TestSplitContentDefinedChunks_ReassemblesToOriginal(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	data := make([]byte, 4*chunkMaxSizeBytes)
+	r.Read(data)
+
+	chunks := splitContentDefinedChunks(data)
+	if len(chunks) < 2 {
+		t.Fatalf("expected more than one chunk for %v bytes of data, got %v", len(data), len(chunks))
+	}
+
+	var reassembled []byte
+	for i, c := range chunks {
+		if i != len(chunks)-1 && len(c) < chunkMinSizeBytes {
+			t.Errorf("non-final chunk %v smaller than chunkMinSizeBytes: %v bytes", i, len(c))
+		}
+		if len(c) > chunkMaxSizeBytes {
+			t.Errorf("chunk %v larger than chunkMaxSizeBytes: %v bytes", i, len(c))
+		}
+
+		reassembled = append(reassembled, c...)
+	}
+
+	if !bytes.Equal(reassembled, data) {
+		t.Fatal("reassembled chunks do not match the original data")
+	}
+}
+
+func TestSplitContentDefinedChunks_StableAcrossUnrelatedAppend(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	base := make([]byte, 2*chunkMaxSizeBytes)
+	r.Read(base)
+
+	appended := append(append([]byte(nil), base...), []byte("trailing data unrelated to earlier chunk boundaries")...)
+
+	chunksBase := splitContentDefinedChunks(base)
+	chunksAppended := splitContentDefinedChunks(appended)
+
+	if len(chunksBase) == 0 || len(chunksAppended) == 0 {
+		t.Fatal("expected at least one chunk")
+	}
+
+	// all but the last chunk of the shorter input should reappear unchanged
+	// at the start of the longer input's chunk list.
+	for i := 0; i < len(chunksBase)-1; i++ {
+		if !bytes.Equal(chunksBase[i], chunksAppended[i]) {
+			t.Fatalf("chunk %v changed after an unrelated append", i)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package block
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionPolicy controls whether and how cached block payloads are
+// compressed on disk before HMAC computation.
+type CompressionPolicy int
+
+// Supported compression policies.
+const (
+	CompressionNone CompressionPolicy = iota
+	CompressionZstdFast
+	CompressionZstdDefault
+)
+
+const (
+	// cacheFormatMagic identifies a cache file that carries a compression
+	// header. Files lacking this prefix are treated as legacy raw+HMAC data.
+	cacheFormatMagic = "KPC1"
+
+	cacheFormatVersion = 1
+
+	codecRaw  = 0
+	codecZstd = 1
+
+	// defaultMinCompressionSizeBytes is the smallest payload that will be
+	// considered for compression; smaller blocks skip it since the header
+	// overhead and CPU cost aren't worth it.
+	defaultMinCompressionSizeBytes = 256
+
+	// entropySampleSize bounds how many bytes are sampled when deciding
+	// whether a payload looks incompressible.
+	entropySampleSize = 4096
+
+	// incompressibleEntropyThreshold is the approximate bits-per-byte above
+	// which data is assumed to already be compressed or random and is passed
+	// through uncompressed.
+	incompressibleEntropyThreshold = 7.5
+)
+
+// cacheFormatHeader is the fixed-size header written before (possibly
+// compressed) payload bytes, prior to HMAC computation:
+//
+//	magic (4 bytes) | version (1 byte) | codec (1 byte) | uncompressed length (8 bytes, big-endian)
+const cacheFormatHeaderSize = 4 + 1 + 1 + 8
+
+// compressionMetrics is a point-in-time snapshot of cumulative compression
+// byte counters, from which a compression ratio can be derived.
+type compressionMetrics struct {
+	UncompressedBytes int64
+	CompressedBytes   int64
+}
+
+func (c *diskBlockCache) compressionMetrics() compressionMetrics {
+	return compressionMetrics{
+		UncompressedBytes: atomic.LoadInt64(&c.uncompressedBytesWritten),
+		CompressedBytes:   atomic.LoadInt64(&c.compressedBytesWritten),
+	}
+}
+
+// encodeCacheBlock wraps data in a cacheFormatHeader, compressing it with the
+// configured CompressionPolicy when it's large enough and doesn't look
+// incompressible. The returned bytes are ready to be passed to appendHMAC.
+func (c *diskBlockCache) encodeCacheBlock(data []byte) []byte {
+	codec := byte(codecRaw)
+	payload := data
+
+	minSize := c.minCompressionSizeBytes
+	if minSize == 0 {
+		minSize = defaultMinCompressionSizeBytes
+	}
+
+	if c.compressionPolicy != CompressionNone && int64(len(data)) >= minSize && !looksIncompressible(data) {
+		if compressed, err := zstdCompress(data, c.compressionPolicy); err == nil && len(compressed) < len(data) {
+			codec = codecZstd
+			payload = compressed
+		}
+	}
+
+	header := make([]byte, cacheFormatHeaderSize)
+	copy(header, cacheFormatMagic)
+	header[4] = cacheFormatVersion
+	header[5] = codec
+	binary.BigEndian.PutUint64(header[6:], uint64(len(data)))
+
+	atomic.AddInt64(&c.uncompressedBytesWritten, int64(len(data)))
+	atomic.AddInt64(&c.compressedBytesWritten, int64(len(payload)))
+
+	return append(header, payload...)
+}
+
+// decodeCacheBlock reverses encodeCacheBlock. Data without the cacheFormatMagic
+// prefix is assumed to be a legacy raw (uncompressed, pre-header) payload and
+// is returned unchanged, for backward compatibility with caches written by
+// older versions of kopia.
+func decodeCacheBlock(data []byte) ([]byte, error) {
+	if len(data) < cacheFormatHeaderSize || string(data[:4]) != cacheFormatMagic {
+		// legacy cache file: raw bytes, no header.
+		return data, nil
+	}
+
+	if data[4] != cacheFormatVersion {
+		return nil, fmt.Errorf("unsupported cache format version %v", data[4])
+	}
+
+	codec := data[5]
+	uncompressedLength := binary.BigEndian.Uint64(data[6:cacheFormatHeaderSize])
+	payload := data[cacheFormatHeaderSize:]
+
+	switch codec {
+	case codecRaw:
+		return payload, nil
+
+	case codecZstd:
+		return zstdDecompress(payload, int(uncompressedLength))
+
+	default:
+		return nil, fmt.Errorf("unknown cache compression codec %v", codec)
+	}
+}
+
+func zstdCompress(data []byte, policy CompressionPolicy) ([]byte, error) {
+	level := zstd.SpeedDefault
+	if policy == CompressionZstdFast {
+		level = zstd.SpeedFastest
+	}
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+
+	return enc.EncodeAll(data, nil), nil
+}
+
+func zstdDecompress(data []byte, uncompressedLength int) ([]byte, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	out, err := dec.DecodeAll(data, make([]byte, 0, uncompressedLength))
+	if err != nil {
+		return nil, errors.New("corrupt compressed cache block: " + err.Error())
+	}
+
+	return out, nil
+}
+
+// looksIncompressible estimates the Shannon entropy of a sample of data and
+// reports whether it's high enough that compression is unlikely to help
+// (e.g. already-compressed or encrypted payloads).
+func looksIncompressible(data []byte) bool {
+	sample := data
+	if len(sample) > entropySampleSize {
+		sample = sample[:entropySampleSize]
+	}
+
+	var histogram [256]int
+	for _, b := range sample {
+		histogram[b]++
+	}
+
+	var entropy float64
+	n := float64(len(sample))
+	for _, count := range histogram {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy >= incompressibleEntropyThreshold
+}
@@ -0,0 +1,93 @@
+package block
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestEncodeDecodeCacheBlock_RoundTrip(t *testing.T) {
+	c := &diskBlockCache{compressionPolicy: CompressionZstdDefault}
+
+	data := bytes.Repeat([]byte("hello world, this is compressible data. "), 100)
+
+	encoded := c.encodeCacheBlock(data)
+
+	decoded, err := decodeCacheBlock(encoded)
+	if err != nil {
+		t.Fatalf("decodeCacheBlock failed: %v", err)
+	}
+
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("round-tripped data does not match original")
+	}
+
+	m := c.compressionMetrics()
+	if m.UncompressedBytes != int64(len(data)) {
+		t.Errorf("UncompressedBytes = %v, want %v", m.UncompressedBytes, len(data))
+	}
+	if m.CompressedBytes >= m.UncompressedBytes {
+		t.Errorf("expected compressible data to shrink: compressed=%v uncompressed=%v", m.CompressedBytes, m.UncompressedBytes)
+	}
+}
+
+func TestEncodeDecodeCacheBlock_IncompressibleDataStoredRaw(t *testing.T) {
+	c := &diskBlockCache{compressionPolicy: CompressionZstdDefault}
+
+	data := make([]byte, entropySampleSize)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := c.encodeCacheBlock(data)
+
+	decoded, err := decodeCacheBlock(encoded)
+	if err != nil {
+		t.Fatalf("decodeCacheBlock failed: %v", err)
+	}
+
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("round-tripped data does not match original")
+	}
+
+	if got := encoded[5]; got != codecRaw {
+		t.Errorf("expected incompressible data to be stored with codecRaw, got codec byte %v", got)
+	}
+}
+
+func TestDecodeCacheBlock_LegacyRawData(t *testing.T) {
+	data := []byte("pre-existing cache file with no compression header")
+
+	decoded, err := decodeCacheBlock(data)
+	if err != nil {
+		t.Fatalf("decodeCacheBlock failed: %v", err)
+	}
+
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("legacy raw data should be returned unchanged")
+	}
+}
+
+func TestDecodeCacheBlock_UnsupportedVersion(t *testing.T) {
+	header := append([]byte(cacheFormatMagic), 99, codecRaw)
+	header = append(header, make([]byte, 8)...)
+
+	if _, err := decodeCacheBlock(header); err == nil {
+		t.Error("expected an error for an unsupported cache format version")
+	}
+}
+
+func TestLooksIncompressible(t *testing.T) {
+	if looksIncompressible(bytes.Repeat([]byte("a"), entropySampleSize)) {
+		t.Error("repeated byte should not look incompressible")
+	}
+
+	random := make([]byte, entropySampleSize)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+
+	if !looksIncompressible(random) {
+		t.Error("random data should look incompressible")
+	}
+}
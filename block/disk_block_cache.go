@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/kopia/kopia/internal/workshare"
 	"github.com/kopia/kopia/storage"
 )
 
@@ -25,44 +27,183 @@ const (
 	cachedSuffix        = ".cached"
 )
 
+// defaultMemoryCacheSizeBytes is used when a diskBlockCache is constructed
+// without an explicit in-memory cache budget.
+const defaultMemoryCacheSizeBytes = 64 << 20 // 64MB
+
 type diskBlockCache struct {
-	st                storage.Storage
+	st                storage.Storage // may be nil, e.g. for the l1 tier of a tiered cache
 	directory         string
 	maxSizeBytes      int64
 	listCacheDuration time.Duration
 	hmacSecret        []byte
 
+	memCache *memoryBlockCache
+	inflight singleflightGroup
+
+	compressionPolicy       CompressionPolicy
+	minCompressionSizeBytes int64
+
+	uncompressedBytesWritten int64
+	compressedBytesWritten   int64
+
+	accessTracker    *accessTracker
+	maxItems         int64
+	minFreeDiskBytes int64
+
+	workPool     *workshare.Pool
+	ownsWorkPool bool
+
 	mu                 sync.Mutex
 	lastTotalSizeBytes int64
 
 	closed chan struct{}
 }
 
-func (c *diskBlockCache) getBlock(virtualBlockID, physicalBlockID string, offset, length int64) ([]byte, error) {
+// newDiskBlockCache creates a diskBlockCache backed by directory, with an L1
+// in-memory LRU tier bounded by memoryCacheSizeBytes (use 0 to disable it, or
+// a negative value to pick defaultMemoryCacheSizeBytes).
+func newDiskBlockCache(st storage.Storage, directory string, maxSizeBytes int64, listCacheDuration time.Duration, hmacSecret []byte, memoryCacheSizeBytes int64) *diskBlockCache {
+	if memoryCacheSizeBytes < 0 {
+		memoryCacheSizeBytes = defaultMemoryCacheSizeBytes
+	}
+
+	c := &diskBlockCache{
+		st:                st,
+		directory:         directory,
+		maxSizeBytes:      maxSizeBytes,
+		listCacheDuration: listCacheDuration,
+		hmacSecret:        hmacSecret,
+		memCache:          newMemoryBlockCache(memoryCacheSizeBytes),
+		compressionPolicy: CompressionNone,
+		accessTracker:     newAccessTracker(),
+		closed:            make(chan struct{}),
+	}
+
+	c.loadAccessJournal()
+
+	return c
+}
+
+// SetCompressionPolicy configures whether cached payloads are compressed
+// before being written to disk, and the minimum payload size (in bytes)
+// eligible for compression. A minSizeBytes of 0 selects
+// defaultMinCompressionSizeBytes.
+func (c *diskBlockCache) SetCompressionPolicy(policy CompressionPolicy, minSizeBytes int64) {
+	c.compressionPolicy = policy
+	c.minCompressionSizeBytes = minSizeBytes
+}
+
+// SetEvictionLimits configures the additional eviction bounds enforced by
+// sweepDirectory on top of maxSizeBytes: an upper bound on the number of
+// cached items (0 for unbounded), and a minimum amount of free disk space to
+// maintain on the cache volume (0 to disable the guard).
+func (c *diskBlockCache) SetEvictionLimits(maxItems, minFreeDiskBytes int64) {
+	c.maxItems = maxItems
+	c.minFreeDiskBytes = minFreeDiskBytes
+}
+
+// SetWorkPool configures an optional workshare.Pool used to parallelize
+// HMAC verification during sweepDirectory's scan, unlinks during eviction,
+// and batch fetches via Prefetch. A nil pool (the default) makes all of
+// those run sequentially on the calling goroutine.
+func (c *diskBlockCache) SetWorkPool(pool *workshare.Pool) {
+	c.workPool = pool
+}
+
+// prefetchRequest is the unit of work shared across workPool workers by
+// Prefetch.
+type prefetchRequest struct {
+	physicalBlockID string
+	err             error
+}
+
+func (c *diskBlockCache) prefetchDispatch(w *workshare.Pool, input interface{}) {
+	req := input.(*prefetchRequest)
+	_, req.err = c.getBlock(req.physicalBlockID, req.physicalBlockID)
+}
+
+// Prefetch concurrently populates the cache for a batch of physical block
+// IDs, using c.workPool to bound how many fetches from storage.Storage run
+// at once. Without a configured pool, blocks are fetched sequentially.
+func (c *diskBlockCache) Prefetch(physicalBlockIDs []string) error {
+	var cs workshare.AsyncGroup
+
+	requests := make([]*prefetchRequest, len(physicalBlockIDs))
+	for i, id := range physicalBlockIDs {
+		req := &prefetchRequest{physicalBlockID: id}
+		requests[i] = req
+
+		if cs.CanShareWork(c.workPool) {
+			cs.RunAsync(c.workPool, c.prefetchDispatch, req)
+		} else {
+			c.prefetchDispatch(c.workPool, req)
+		}
+	}
+	cs.Wait()
+
+	for _, req := range requests {
+		if req.err != nil && req.err != storage.ErrBlockNotFound {
+			return req.err
+		}
+	}
+
+	return nil
+}
+
+// getBlock returns the full (unsliced) contents of virtualBlockID, fetching
+// and caching the whole block from physicalBlockID on a miss. Get applies
+// offset/length to the result, so that a given virtualBlockID is always
+// cached under the same key regardless of which range is requested first.
+func (c *diskBlockCache) getBlock(virtualBlockID, physicalBlockID string) ([]byte, error) {
+	if b, ok := c.memCache.get(virtualBlockID); ok {
+		c.accessTracker.recordAccess(virtualBlockID)
+		return b, nil
+	}
+
+	b, err := c.inflight.do(virtualBlockID, func() ([]byte, error) {
+		return c.getBlockUncached(virtualBlockID, physicalBlockID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.memCache.put(virtualBlockID, b)
+
+	return b, nil
+}
+
+func (c *diskBlockCache) getBlockUncached(virtualBlockID, physicalBlockID string) ([]byte, error) {
 	fn := c.cachedItemName(virtualBlockID)
 
-	b, err := ioutil.ReadFile(fn)
+	b, err := c.readCacheBlockFile(fn)
 	if err == nil {
-		b, err := c.verifyHMAC(b)
+		b, err := decodeCacheBlock(b)
 		if err == nil {
 			// retrieved from blockCache and HMAC valid
+			c.accessTracker.recordAccess(virtualBlockID)
 			return b, nil
 		}
 
-		// ignore malformed blocks
 		log.Printf("warning: malformed block %v: %v", virtualBlockID, err)
 	} else if !os.IsNotExist(err) {
 		log.Printf("warning: unable to read blockCache file %v: %v", fn, err)
 	}
 
-	b, err = c.st.GetBlock(physicalBlockID, offset, length)
+	if c.st == nil {
+		// pure cache tier (e.g. the l1 of a tiered cache) with no backing
+		// storage.Storage to fall back to on a miss.
+		return nil, storage.ErrBlockNotFound
+	}
+
+	b, err = c.st.GetBlock(physicalBlockID, 0, -1)
 	if err == storage.ErrBlockNotFound {
 		// not found in underlying storage
 		return nil, err
 	}
 
 	if err == nil {
-		if err := c.writeFileAtomic(fn, c.appendHMAC(b)); err != nil {
+		if err := c.writeCacheBlockFile(fn, c.encodeCacheBlock(b)); err != nil {
 			log.Printf("warning: unable to write file %v: %v", fn, err)
 		}
 	}
@@ -87,39 +228,112 @@ func applyOffsetAndLength(b []byte, offset, length int64) ([]byte, error) {
 }
 
 func (c *diskBlockCache) putBlock(blockID string, data []byte) error {
-	err := c.st.PutBlock(blockID, data)
-	if err != nil {
-		return err
+	if c.st != nil {
+		if err := c.st.PutBlock(blockID, data); err != nil {
+			return err
+		}
 	}
 
-	c.writeFileAtomic(filepath.Join(c.directory, blockID)+cachedSuffix, c.appendHMAC(data))
+	c.writeCacheBlockFile(filepath.Join(c.directory, blockID)+cachedSuffix, c.encodeCacheBlock(data))
+	c.memCache.delete(blockID)
+	c.accessTracker.recordAccess(blockID)
 	c.deleteListCache()
 	return nil
 }
 
+func (c *diskBlockCache) deleteBlock(key string) error {
+	c.memCache.delete(key)
+	c.accessTracker.forget(key)
+
+	if err := os.Remove(c.cachedItemName(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Get implements BlockCache.
+func (c *diskBlockCache) Get(virtualBlockID, physicalBlockID string, offset, length int64) ([]byte, error) {
+	b, err := c.getBlock(virtualBlockID, physicalBlockID)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyOffsetAndLength(b, offset, length)
+}
+
+// Metrics implements BlockCache.
+func (c *diskBlockCache) Metrics() CacheMetrics {
+	mem := c.memCache.metrics()
+	comp := c.compressionMetrics()
+
+	return CacheMetrics{
+		Hits:              mem.Hits,
+		Misses:            mem.Misses,
+		Evictions:         mem.Evictions,
+		UncompressedBytes: comp.UncompressedBytes,
+		CompressedBytes:   comp.CompressedBytes,
+	}
+}
+
+// Put implements BlockCache.
+func (c *diskBlockCache) Put(blockID string, data []byte) error {
+	return c.putBlock(blockID, data)
+}
+
+// Delete implements BlockCache.
+func (c *diskBlockCache) Delete(key string) error {
+	return c.deleteBlock(key)
+}
+
+// List implements BlockCache.
+func (c *diskBlockCache) List(full bool) ([]Info, error) {
+	return c.listIndexBlocks(full)
+}
+
+// Close implements BlockCache.
+func (c *diskBlockCache) Close() error {
+	return c.close()
+}
+
 func (c *diskBlockCache) listIndexBlocks(full bool) ([]Info, error) {
-	var cachedListFile string
+	memCacheKey := "list-active"
+	cachedListFile := c.cachedItemName("list-active")
 
 	if full {
+		memCacheKey = "list-full"
 		cachedListFile = c.cachedItemName("list-full")
-	} else {
-		cachedListFile = c.cachedItemName("list-active")
 	}
 
-	f, err := os.Open(cachedListFile)
-	if err == nil {
-		defer f.Close()
+	if data, ok := c.memCache.get(memCacheKey); ok {
+		var blocks []Info
+		if err := json.Unmarshal(data, &blocks); err == nil {
+			log.Debug().Bool("full", full).Msg("listing index blocks from memory cache")
+			c.accessTracker.recordAccess(memCacheKey)
+			return blocks, nil
+		}
+	}
 
-		st, err := f.Stat()
-		if err == nil {
-			expirationTime := st.ModTime().UTC().Add(c.listCacheDuration)
-			if time.Now().UTC().Before(expirationTime) {
-				log.Debug().Bool("full", full).Str("file", cachedListFile).Msg("listing index blocks from cache")
-				return c.readBlocksFromCacheFile(f)
+	st, err := os.Stat(cachedListFile)
+	if err == nil {
+		expirationTime := st.ModTime().UTC().Add(c.listCacheDuration)
+		if time.Now().UTC().Before(expirationTime) {
+			log.Debug().Bool("full", full).Str("file", cachedListFile).Msg("listing index blocks from cache")
+			blocks, data, err := c.readBlocksFromCacheFile(cachedListFile)
+			if err == nil {
+				c.memCache.put(memCacheKey, data)
+				c.accessTracker.recordAccess(memCacheKey)
+				return blocks, nil
 			}
 		}
 	} else {
-		log.Warn().Msgf("unable to open cache file %v: %v", cachedListFile, err)
+		log.Warn().Msgf("unable to stat cache file %v: %v", cachedListFile, err)
+	}
+
+	if c.st == nil {
+		// pure cache tier (e.g. the l1 of a tiered cache) with no backing
+		// storage.Storage to list from.
+		return nil, fmt.Errorf("blockCache has no backing storage to list from")
 	}
 
 	log.Debug().Bool("full", full).Msg("listing index blocks from source")
@@ -128,9 +342,10 @@ func (c *diskBlockCache) listIndexBlocks(full bool) ([]Info, error) {
 		log.Debug().Bool("full", full).Msgf("saving %v index blocks to cache: %v", len(blocks), cachedListFile)
 		// save to blockCache
 		if data, err := json.Marshal(blocks); err == nil {
-			if err := c.writeFileAtomic(cachedListFile, c.appendHMAC(data)); err != nil {
+			if err := c.writeCacheBlockFile(cachedListFile, c.encodeCacheBlock(data)); err != nil {
 				log.Printf("warning: can't save list: %v", err)
 			}
+			c.memCache.put(memCacheKey, data)
 		}
 	}
 
@@ -141,24 +356,24 @@ func (c *diskBlockCache) cachedItemName(name string) string {
 	return filepath.Join(c.directory, name+cachedSuffix)
 }
 
-func (c *diskBlockCache) readBlocksFromCacheFile(f *os.File) ([]Info, error) {
+func (c *diskBlockCache) readBlocksFromCacheFile(fn string) ([]Info, []byte, error) {
 	var blocks []Info
-	data, err := ioutil.ReadAll(f)
+
+	data, err := c.readCacheBlockFile(fn)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	data, err = c.verifyHMAC(data)
+	data, err = decodeCacheBlock(data)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := json.Unmarshal(data, &blocks); err != nil {
-		return nil, fmt.Errorf("can't unmarshal cached list results: %v", err)
+		return nil, nil, fmt.Errorf("can't unmarshal cached list results: %v", err)
 	}
 
-	return blocks, nil
-
+	return blocks, data, nil
 }
 
 func (c *diskBlockCache) readBlocksFromSource(maxCompactions int) ([]Info, error) {
@@ -243,6 +458,12 @@ func (c *diskBlockCache) writeFileAtomic(fname string, contents []byte) error {
 
 func (c *diskBlockCache) close() error {
 	close(c.closed)
+	c.persistAccessJournal()
+
+	if c.ownsWorkPool {
+		c.workPool.Close()
+	}
+
 	return nil
 }
 
@@ -253,19 +474,27 @@ func (c *diskBlockCache) sweepDirectoryPeriodically() {
 			return
 
 		case <-time.After(sweepCacheFrequency):
-			err := c.sweepDirectory()
-			if err != nil {
+			if err := c.sweepDirectory(); err != nil {
 				log.Printf("warning: blockCache sweep failed: %v", err)
 			}
+
+			c.persistAccessJournal()
 		}
 	}
 }
 
+// cacheItemKey strips cachedSuffix from a cache file's name, giving the key
+// under which its access time is tracked.
+func cacheItemKey(fileName string) string {
+	return strings.TrimSuffix(fileName, cachedSuffix)
+}
+
 func (c *diskBlockCache) sweepDirectory() (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.maxSizeBytes == 0 {
+	if c.maxSizeBytes == 0 && c.maxItems == 0 && c.minFreeDiskBytes <= 0 {
+		// no eviction policy configured at all.
 		return nil
 	}
 
@@ -281,32 +510,318 @@ func (c *diskBlockCache) sweepDirectory() (err error) {
 		return err
 	}
 
-	sort.Slice(items, func(i, j int) bool {
-		return items[i].ModTime().After(items[j].ModTime())
-	})
-
-	var totalRetainedSize int64
+	var scanned []os.FileInfo
 	for _, it := range items {
 		if !strings.HasSuffix(it.Name(), cachedSuffix) {
 			continue
 		}
-		if totalRetainedSize > c.maxSizeBytes {
-			fn := filepath.Join(c.directory, it.Name())
-			log.Debug().Msgf("deleting %v", fn)
-			if err := os.Remove(fn); err != nil {
-				log.Printf("warning: unable to remove %v: %v", fn, err)
+
+		if strings.HasPrefix(it.Name(), chunkFilePrefix) {
+			// chunk files are content-addressed and may be shared by several
+			// manifests; they're not individually access-tracked, so leave
+			// reclaiming them to a chunk-aware GC rather than this LRU sweep.
+			continue
+		}
+
+		scanned = append(scanned, it)
+	}
+
+	candidates := c.verifyCandidates(scanned)
+
+	// sort most-recently-used first, so we can keep a prefix and evict the tail.
+	sort.Slice(candidates, func(i, j int) bool {
+		ti := c.accessTracker.accessTime(cacheItemKey(candidates[i].item.Name()), candidates[i].item.ModTime())
+		tj := c.accessTracker.accessTime(cacheItemKey(candidates[j].item.Name()), candidates[j].item.ModTime())
+		return ti.After(tj)
+	})
+
+	sizeBudget := c.maxSizeBytes
+	if sizeBudget <= 0 {
+		// no size budget configured - maxItems/minFreeDiskBytes alone drive eviction.
+		sizeBudget = math.MaxInt64
+	}
+	if aggressive, ok := c.lowDiskSpaceBudget(); ok && aggressive < sizeBudget {
+		sizeBudget = aggressive
+	}
+
+	var toEvict []os.FileInfo
+	var evictReasons []string
+
+	var totalRetainedSize int64
+	var retainedCount int64
+	var retainedNames []string
+	for _, candidate := range candidates {
+		overSize := totalRetainedSize+candidate.sizeBytes > sizeBudget
+		overCount := c.maxItems > 0 && retainedCount >= c.maxItems
+
+		if overSize || overCount {
+			reason := "size-budget"
+			if overCount {
+				reason = "item-count-budget"
 			}
-		} else {
-			totalRetainedSize += it.Size()
+
+			toEvict = append(toEvict, candidate.item)
+			evictReasons = append(evictReasons, reason)
+			continue
 		}
+
+		totalRetainedSize += candidate.sizeBytes
+		retainedCount++
+		retainedNames = append(retainedNames, candidate.item.Name())
+	}
+
+	c.evictCacheFiles(toEvict, evictReasons)
+	c.gcOrphanedChunks(retainedNames)
+
+	if c.maxSizeBytes > 0 {
+		log.Debug().Msgf("finished sweeping directory in %v and retained %v/%v bytes (%v items, %v %%)", time.Since(t0), totalRetainedSize, c.maxSizeBytes, retainedCount, 100*totalRetainedSize/c.maxSizeBytes)
+	} else {
+		log.Debug().Msgf("finished sweeping directory in %v and retained %v bytes (%v items)", time.Since(t0), totalRetainedSize, retainedCount)
 	}
-	log.Debug().Msgf("finished sweeping directory in %v and retained %v/%v bytes (%v %%)", time.Since(t0), totalRetainedSize, c.maxSizeBytes, 100*totalRetainedSize/c.maxSizeBytes)
 	c.lastTotalSizeBytes = totalRetainedSize
 	return nil
 }
 
+// gcOrphanedChunks removes chunk files that are no longer referenced by any
+// manifest surviving this sweep. Chunk files are content-addressed and
+// shared by whichever manifests reference them, so they aren't individually
+// access-tracked or evicted by the LRU pass above - instead, a chunk is kept
+// only as long as at least one retained manifest still points at it.
+func (c *diskBlockCache) gcOrphanedChunks(retainedNames []string) {
+	liveChunks := map[string]bool{}
+	for _, name := range retainedNames {
+		hashes, ok := c.manifestChunkHashes(filepath.Join(c.directory, name))
+		if !ok {
+			continue
+		}
+
+		for _, hash := range hashes {
+			liveChunks[hash] = true
+		}
+	}
+
+	items, err := ioutil.ReadDir(c.directory)
+	if err != nil {
+		return
+	}
+
+	var orphaned []os.FileInfo
+	var reasons []string
+	for _, it := range items {
+		if !strings.HasPrefix(it.Name(), chunkFilePrefix) || !strings.HasSuffix(it.Name(), cachedSuffix) {
+			continue
+		}
+
+		hash := strings.TrimSuffix(strings.TrimPrefix(it.Name(), chunkFilePrefix), cachedSuffix)
+		if liveChunks[hash] {
+			continue
+		}
+
+		orphaned = append(orphaned, it)
+		reasons = append(reasons, "orphaned-chunk")
+	}
+
+	c.evictCacheFiles(orphaned, reasons)
+}
+
+// verifyCandidateRequest is the unit of work shared across workPool workers
+// by verifyCandidates.
+type verifyCandidateRequest struct {
+	item      os.FileInfo
+	valid     bool
+	sizeBytes int64
+}
+
+func (c *diskBlockCache) verifyCandidateDispatch(w *workshare.Pool, input interface{}) {
+	req := input.(*verifyCandidateRequest)
+	req.valid, req.sizeBytes = c.verifyCacheFileOnDisk(req.item)
+}
+
+// sweepCandidate pairs a verified cache file with the sizeBytes verifyCandidates
+// already computed for it (verifyCacheFileOnDisk's size, above), so sweepDirectory
+// can budget against it without re-reading and re-verifying the file a second time.
+type sweepCandidate struct {
+	item      os.FileInfo
+	sizeBytes int64
+}
+
+// verifyCandidates HMAC-verifies each candidate cache file, fanning the
+// verification out across c.workPool when one is configured, and evicts
+// anything that fails verification (e.g. a file truncated by a crash).
+func (c *diskBlockCache) verifyCandidates(items []os.FileInfo) []sweepCandidate {
+	requests := make([]*verifyCandidateRequest, len(items))
+
+	var cs workshare.AsyncGroup
+	for i, it := range items {
+		req := &verifyCandidateRequest{item: it}
+		requests[i] = req
+
+		if cs.CanShareWork(c.workPool) {
+			cs.RunAsync(c.workPool, c.verifyCandidateDispatch, req)
+		} else {
+			c.verifyCandidateDispatch(c.workPool, req)
+		}
+	}
+	cs.Wait()
+
+	var kept []sweepCandidate
+	var corrupt []os.FileInfo
+	var corruptReasons []string
+	for _, req := range requests {
+		if req.valid {
+			kept = append(kept, sweepCandidate{item: req.item, sizeBytes: req.sizeBytes})
+		} else {
+			corrupt = append(corrupt, req.item)
+			corruptReasons = append(corruptReasons, "corrupt")
+		}
+	}
+
+	c.evictCacheFiles(corrupt, corruptReasons)
+
+	return kept
+}
+
+// verifyCacheFileOnDisk reports whether a cache file still has a valid HMAC
+// and decodes cleanly, without returning its contents, along with the disk
+// footprint sweepDirectory should charge against its size budget for it: the
+// file's own size, plus - for a chunk manifest, whose own file size is
+// negligible - the chunk bytes it references. For a chunk manifest it
+// deliberately stops short of reassembling the full payload - see
+// verifyChunkManifestCheaply - since this runs on every sweep and the whole
+// point of chunking large entries is to avoid re-touching all their bytes.
+func (c *diskBlockCache) verifyCacheFileOnDisk(it os.FileInfo) (valid bool, sizeBytes int64) {
+	fn := filepath.Join(c.directory, it.Name())
+
+	if manifest, ok := c.readManifestIfPresent(fn); ok {
+		if !c.verifyChunkManifestCheaply(manifest) {
+			return false, 0
+		}
+
+		return true, it.Size() + manifestChunkBytes(manifest)
+	}
+
+	b, err := c.readCacheBlockFile(fn)
+	if err != nil {
+		return false, 0
+	}
+
+	if _, err := decodeCacheBlock(b); err != nil {
+		return false, 0
+	}
+
+	return true, it.Size()
+}
+
+// verifyChunkManifestCheaply reports whether a chunk manifest (already
+// HMAC-verified by readManifestIfPresent) still looks intact, without
+// reassembling - reading, concatenating and zstd-decompressing - the full
+// payload it describes. It only confirms that each referenced chunk file is
+// still present and HMAC-valid; a chunk that fails either check is treated
+// as corrupting the manifest that references it.
+func (c *diskBlockCache) verifyChunkManifestCheaply(manifest chunkManifest) bool {
+	for _, entry := range manifest.Chunks {
+		raw, err := ioutil.ReadFile(c.chunkFileName(entry.Hash))
+		if err != nil {
+			return false
+		}
+
+		if _, err := c.verifyHMAC(raw); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lowDiskSpaceBudget returns a reduced size budget when free disk space on
+// the cache volume is below minFreeDiskBytes, forcing sweepDirectory to
+// evict more aggressively than maxSizeBytes alone would. ok is false when
+// minFreeDiskBytes isn't configured or free space couldn't be determined.
+func (c *diskBlockCache) lowDiskSpaceBudget() (budget int64, ok bool) {
+	if c.minFreeDiskBytes <= 0 {
+		return 0, false
+	}
+
+	free, err := freeDiskSpaceBytes(c.directory)
+	if err != nil {
+		log.Printf("warning: unable to determine free disk space for %v: %v", c.directory, err)
+		return 0, false
+	}
+
+	if free >= c.minFreeDiskBytes {
+		return 0, false
+	}
+
+	shortfall := c.minFreeDiskBytes - free
+	budget = c.lastTotalSizeBytes - shortfall
+	if budget < 0 {
+		budget = 0
+	}
+
+	log.Warn().Int64("freeBytes", free).Int64("minFreeDiskBytes", c.minFreeDiskBytes).Msg("low disk space, evicting cache aggressively")
+
+	return budget, true
+}
+
+// evictCacheFileRequest is the unit of work shared across workPool workers
+// by evictCacheFiles.
+type evictCacheFileRequest struct {
+	item   os.FileInfo
+	reason string
+}
+
+func (c *diskBlockCache) evictCacheFileDispatch(w *workshare.Pool, input interface{}) {
+	req := input.(*evictCacheFileRequest)
+	c.unlinkCacheFile(req.item, req.reason)
+}
+
+// evictCacheFiles removes the given cache files (with a 1:1 reasons slice
+// for the structured eviction log), fanning unlinks out across c.workPool
+// when one is configured.
+func (c *diskBlockCache) evictCacheFiles(items []os.FileInfo, reasons []string) {
+	var cs workshare.AsyncGroup
+	for i, it := range items {
+		req := &evictCacheFileRequest{item: it, reason: reasons[i]}
+
+		if cs.CanShareWork(c.workPool) {
+			cs.RunAsync(c.workPool, c.evictCacheFileDispatch, req)
+		} else {
+			c.evictCacheFileDispatch(c.workPool, req)
+		}
+	}
+	cs.Wait()
+}
+
+// unlinkCacheFile removes a single cache file and forgets its tracked access
+// time, emitting a structured event describing why it was evicted.
+func (c *diskBlockCache) unlinkCacheFile(it os.FileInfo, reason string) {
+	fn := filepath.Join(c.directory, it.Name())
+
+	if err := os.Remove(fn); err != nil {
+		log.Printf("warning: unable to remove %v: %v", fn, err)
+		return
+	}
+
+	c.accessTracker.forget(cacheItemKey(it.Name()))
+
+	log.Info().
+		Str("file", fn).
+		Int64("sizeBytes", it.Size()).
+		Str("reason", reason).
+		Msg("evicted cache entry")
+}
+
 func (c *diskBlockCache) deleteListCache() {
 	log.Printf("deleting list cache")
 	os.Remove(c.cachedItemName("list-full"))
 	os.Remove(c.cachedItemName("list-active"))
-}
\ No newline at end of file
+	c.memCache.delete("list-full")
+	c.memCache.delete("list-active")
+	c.accessTracker.forget("list-full")
+	c.accessTracker.forget("list-active")
+}
+
+// cacheMetrics returns a snapshot of the in-memory (L1) cache hit/miss/eviction counters.
+func (c *diskBlockCache) cacheMetrics() memoryCacheMetrics {
+	return c.memCache.metrics()
+}
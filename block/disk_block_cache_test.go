@@ -0,0 +1,106 @@
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestCacheFile(t *testing.T, c *diskBlockCache, name string, size int) {
+	t.Helper()
+
+	if err := c.writeFileAtomic(c.cachedItemName(name), c.appendHMAC(make([]byte, size))); err != nil {
+		t.Fatalf("unable to write test cache file %v: %v", name, err)
+	}
+}
+
+func countCacheFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unable to read directory %v: %v", dir, err)
+	}
+
+	return len(entries)
+}
+
+func totalDirBytes(t *testing.T, dir string) int64 {
+	t.Helper()
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unable to read directory %v: %v", dir, err)
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size()
+	}
+
+	return total
+}
+
+// TestSweepDirectory_BudgetsChunkedManifestsByReferencedChunkBytes verifies
+// that a chunk manifest counts toward the size budget by the bytes of the
+// chunks it references, not just its own (tiny) file size - otherwise a
+// workload made entirely of >chunkedCacheThresholdBytes blocks never gets
+// evicted no matter how small maxSizeBytes is.
+func TestSweepDirectory_BudgetsChunkedManifestsByReferencedChunkBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const blockSize = 5 << 20 // 5MB, above chunkedCacheThresholdBytes
+
+	c := newDiskBlockCache(nil, dir, 4096, defaultListCacheDuration, nil, 0)
+
+	for i := 0; i < 5; i++ {
+		encoded := make([]byte, blockSize)
+		for j := range encoded {
+			// vary contents per block so chunks aren't deduped across blocks.
+			encoded[j] = byte(i*31 + j)
+		}
+
+		if err := c.writeCacheBlockFile(c.cachedItemName(filepath.Base(dir)+string(rune('a'+i))), encoded); err != nil {
+			t.Fatalf("writeCacheBlockFile failed: %v", err)
+		}
+	}
+
+	if err := c.sweepDirectory(); err != nil {
+		t.Fatalf("sweepDirectory failed: %v", err)
+	}
+
+	if got := totalDirBytes(t, dir); got > blockSize {
+		t.Fatalf("expected sweepDirectory to evict down to roughly its 4096-byte budget, %v bytes remained on disk", got)
+	}
+}
+
+// TestSweepDirectory_ItemCountBudgetWithoutSizeBudget verifies that
+// SetEvictionLimits' maxItems cap is enforced even when no byte size budget
+// is configured (maxSizeBytes == 0).
+func TestSweepDirectory_ItemCountBudgetWithoutSizeBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newDiskBlockCache(nil, dir, 0, defaultListCacheDuration, nil, 0)
+	c.SetEvictionLimits(2, 0)
+
+	for i := 0; i < 5; i++ {
+		writeTestCacheFile(t, c, filepath.Base(dir)+string(rune('a'+i)), 16)
+	}
+
+	if err := c.sweepDirectory(); err != nil {
+		t.Fatalf("sweepDirectory failed: %v", err)
+	}
+
+	if got := countCacheFiles(t, dir); got != 2 {
+		t.Fatalf("expected maxItems=2 to be enforced, found %v cache files", got)
+	}
+}
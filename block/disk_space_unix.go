@@ -0,0 +1,16 @@
+//go:build !windows
+
+package block
+
+import "syscall"
+
+// freeDiskSpaceBytes returns the number of bytes free on the filesystem that
+// backs dir, used by sweepDirectory's minFreeDiskBytes guard.
+func freeDiskSpaceBytes(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
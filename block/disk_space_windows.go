@@ -0,0 +1,36 @@
+//go:build windows
+
+package block
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceEx = kernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+// freeDiskSpaceBytes returns the number of bytes free on the volume that
+// backs dir, used by sweepDirectory's minFreeDiskBytes guard.
+func freeDiskSpaceBytes(dir string) (int64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var freeBytesAvailable uint64
+
+	ret, _, err := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&freeBytesAvailable)),
+		0,
+		0,
+	)
+	if ret == 0 {
+		return 0, err
+	}
+
+	return int64(freeBytesAvailable), nil
+}
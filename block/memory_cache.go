@@ -0,0 +1,185 @@
+package block
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// memoryBlockCacheEntry is a single entry in memoryBlockCache's LRU list.
+type memoryBlockCacheEntry struct {
+	key   string
+	value []byte
+}
+
+// memoryBlockCache is a bounded in-memory LRU cache of decoded/verified block
+// payloads, keyed by virtual block ID (or other cache key such as a list
+// cache name).
+type memoryBlockCache struct {
+	maxBytes int64
+
+	mu           sync.Mutex
+	ll           *list.List
+	items        map[string]*list.Element
+	currentBytes int64
+
+	hitCount      int64
+	missCount     int64
+	evictionCount int64
+}
+
+func newMemoryBlockCache(maxBytes int64) *memoryBlockCache {
+	return &memoryBlockCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// get returns the cached value for key, if present, promoting it to the
+// most-recently-used position.
+func (c *memoryBlockCache) get(key string) ([]byte, bool) {
+	if c == nil || c.maxBytes <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		atomic.AddInt64(&c.missCount, 1)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(e)
+	atomic.AddInt64(&c.hitCount, 1)
+
+	return e.Value.(*memoryBlockCacheEntry).value, true
+}
+
+// put inserts or replaces the cached value for key, evicting
+// least-recently-used entries as needed to stay within maxBytes.
+func (c *memoryBlockCache) put(key string, value []byte) {
+	if c == nil || c.maxBytes <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(value)) > c.maxBytes {
+		// too large to cache: drop any older value so it isn't served stale.
+		if e, ok := c.items[key]; ok {
+			c.removeElementLocked(e)
+		}
+
+		return
+	}
+
+	if e, ok := c.items[key]; ok {
+		c.currentBytes -= int64(len(e.Value.(*memoryBlockCacheEntry).value))
+		e.Value.(*memoryBlockCacheEntry).value = value
+		c.currentBytes += int64(len(value))
+		c.ll.MoveToFront(e)
+	} else {
+		e := c.ll.PushFront(&memoryBlockCacheEntry{key: key, value: value})
+		c.items[key] = e
+		c.currentBytes += int64(len(value))
+	}
+
+	for c.currentBytes > c.maxBytes {
+		c.evictOldestLocked()
+	}
+}
+
+// delete invalidates the cached value for key, if any.
+func (c *memoryBlockCache) delete(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.removeElementLocked(e)
+	}
+}
+
+func (c *memoryBlockCache) evictOldestLocked() {
+	e := c.ll.Back()
+	if e == nil {
+		return
+	}
+
+	c.removeElementLocked(e)
+	atomic.AddInt64(&c.evictionCount, 1)
+}
+
+func (c *memoryBlockCache) removeElementLocked(e *list.Element) {
+	c.ll.Remove(e)
+	entry := e.Value.(*memoryBlockCacheEntry)
+	delete(c.items, entry.key)
+	c.currentBytes -= int64(len(entry.value))
+}
+
+// memoryCacheMetrics is a point-in-time snapshot of memoryBlockCache counters.
+type memoryCacheMetrics struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+func (c *memoryBlockCache) metrics() memoryCacheMetrics {
+	if c == nil {
+		return memoryCacheMetrics{}
+	}
+
+	return memoryCacheMetrics{
+		Hits:      atomic.LoadInt64(&c.hitCount),
+		Misses:    atomic.LoadInt64(&c.missCount),
+		Evictions: atomic.LoadInt64(&c.evictionCount),
+	}
+}
+
+// singleflightCall represents an in-flight or completed singleflightGroup.do call.
+type singleflightCall struct {
+	wg    sync.WaitGroup
+	value []byte
+	err   error
+}
+
+// singleflightGroup coalesces concurrent callers requesting the same key
+// into a single execution of fn.
+type singleflightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*singleflightCall
+}
+
+func (g *singleflightGroup) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+
+	c := &singleflightCall{}
+	c.wg.Add(1)
+
+	if g.calls == nil {
+		g.calls = map[string]*singleflightCall{}
+	}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.value, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return c.value, c.err
+}
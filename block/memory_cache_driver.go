@@ -0,0 +1,115 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kopia/kopia/storage"
+)
+
+// memoryCacheDriver is the "memory" BlockCache driver: it keeps cached block
+// payloads and index-block listings purely in a bounded in-memory LRU, with
+// no on-disk footprint of its own. st (which may be nil, e.g. for the l1 tier
+// of a tiered cache) is consulted on a miss and written through to by Put.
+type memoryCacheDriver struct {
+	st       storage.Storage
+	cache    *memoryBlockCache
+	inflight singleflightGroup
+}
+
+func newMemoryCacheDriver(st storage.Storage, maxBytes int64) *memoryCacheDriver {
+	return &memoryCacheDriver{
+		st:    st,
+		cache: newMemoryBlockCache(maxBytes),
+	}
+}
+
+// Get implements BlockCache.
+func (c *memoryCacheDriver) Get(virtualBlockID, physicalBlockID string, offset, length int64) ([]byte, error) {
+	if b, ok := c.cache.get(virtualBlockID); ok {
+		return applyOffsetAndLength(b, offset, length)
+	}
+
+	if c.st == nil {
+		return nil, storage.ErrBlockNotFound
+	}
+
+	b, err := c.inflight.do(virtualBlockID, func() ([]byte, error) {
+		return c.st.GetBlock(physicalBlockID, 0, -1)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.put(virtualBlockID, b)
+
+	return applyOffsetAndLength(b, offset, length)
+}
+
+// Put implements BlockCache.
+func (c *memoryCacheDriver) Put(blockID string, data []byte) error {
+	if c.st != nil {
+		if err := c.st.PutBlock(blockID, data); err != nil {
+			return err
+		}
+	}
+
+	c.cache.put(blockID, data)
+	c.cache.delete("list-active")
+	c.cache.delete("list-full")
+
+	return nil
+}
+
+// Delete implements BlockCache.
+func (c *memoryCacheDriver) Delete(key string) error {
+	c.cache.delete(key)
+	return nil
+}
+
+// List implements BlockCache.
+func (c *memoryCacheDriver) List(full bool) ([]Info, error) {
+	memCacheKey := "list-active"
+	if full {
+		memCacheKey = "list-full"
+	}
+
+	if data, ok := c.cache.get(memCacheKey); ok {
+		var blocks []Info
+		if err := json.Unmarshal(data, &blocks); err == nil {
+			return blocks, nil
+		}
+	}
+
+	if c.st == nil {
+		return nil, fmt.Errorf("memory cache has no backing storage to list from")
+	}
+
+	blocks, err := listIndexBlocksFromStorage(c.st, full)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(blocks); err == nil {
+		c.cache.put(memCacheKey, data)
+	}
+
+	return blocks, nil
+}
+
+// Close implements BlockCache.
+func (c *memoryCacheDriver) Close() error {
+	return nil
+}
+
+// Metrics implements BlockCache. A memoryCacheDriver never compresses, so
+// UncompressedBytes/CompressedBytes are always zero.
+func (c *memoryCacheDriver) Metrics() CacheMetrics {
+	m := c.cache.metrics()
+
+	return CacheMetrics{
+		Hits:      m.Hits,
+		Misses:    m.Misses,
+		Evictions: m.Evictions,
+	}
+}
@@ -0,0 +1,101 @@
+package block
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryBlockCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newMemoryBlockCache(10)
+
+	c.put("a", []byte("12345"))
+	c.put("b", []byte("12345"))
+
+	// touch "a" so "b" becomes the least-recently-used entry.
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected \"a\" to be cached")
+	}
+
+	c.put("c", []byte("12345"))
+
+	if _, ok := c.get("b"); ok {
+		t.Error("expected \"b\" to have been evicted")
+	}
+
+	if _, ok := c.get("a"); !ok {
+		t.Error("expected \"a\" to still be cached")
+	}
+
+	if _, ok := c.get("c"); !ok {
+		t.Error("expected \"c\" to be cached")
+	}
+
+	m := c.metrics()
+	if m.Evictions != 1 {
+		t.Errorf("expected 1 eviction, got %v", m.Evictions)
+	}
+}
+
+func TestMemoryBlockCache_ValueLargerThanBudgetIsNotCached(t *testing.T) {
+	c := newMemoryBlockCache(4)
+
+	c.put("a", []byte("12345"))
+
+	if _, ok := c.get("a"); ok {
+		t.Error("expected a value larger than maxBytes to never be cached")
+	}
+}
+
+func TestSingleflightGroup_CoalescesConcurrentCalls(t *testing.T) {
+	var g singleflightGroup
+
+	var calls int32
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+
+	fn := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-proceed
+		return []byte("value"), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 5)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b, _ := g.do("key", fn)
+		results[0] = b
+	}()
+
+	<-started // fn is now blocked on proceed, with its call registered in g.calls.
+
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b, _ := g.do("key", fn)
+			results[i] = b
+		}(i)
+	}
+
+	// give the goroutines above a chance to join the in-flight call before
+	// it's allowed to complete.
+	time.Sleep(20 * time.Millisecond)
+	close(proceed)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("expected fn to run exactly once, ran %v times", got)
+	}
+
+	for i, b := range results {
+		if string(b) != "value" {
+			t.Errorf("result %v = %q, want %q", i, b, "value")
+		}
+	}
+}
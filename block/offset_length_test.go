@@ -0,0 +1,95 @@
+package block
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kopia/kopia/storage"
+)
+
+// fakeStorage is a minimal in-memory storage.Storage for exercising
+// diskBlockCache without a real backing store.
+type fakeStorage struct {
+	data map[string][]byte
+}
+
+func (f *fakeStorage) GetBlock(id string, offset, length int64) ([]byte, error) {
+	b, ok := f.data[id]
+	if !ok {
+		return nil, storage.ErrBlockNotFound
+	}
+
+	return applyOffsetAndLength(b, offset, length)
+}
+
+func (f *fakeStorage) PutBlock(id string, data []byte) error {
+	if f.data == nil {
+		f.data = map[string][]byte{}
+	}
+
+	f.data[id] = data
+
+	return nil
+}
+
+func (f *fakeStorage) DeleteBlock(id string) error {
+	delete(f.data, id)
+	return nil
+}
+
+func (f *fakeStorage) ListBlocks(prefix string) (<-chan storage.BlockMetadata, func()) {
+	ch := make(chan storage.BlockMetadata)
+	close(ch)
+
+	return ch, func() {}
+}
+
+// TestGet_OffsetLengthConsistentAcrossDrivers verifies that repeated Get
+// calls against the same virtualBlockID with different (offset, length)
+// ranges return consistent slices of the same underlying block, regardless
+// of which BlockCache driver serves them.
+func TestGet_OffsetLengthConsistentAcrossDrivers(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10) // 100 bytes
+
+	mem := newMemoryCacheDriver(nil, 1<<20)
+	mem.cache.put("v1", data)
+
+	tiered := newTieredBlockCache(newMemoryCacheDriver(nil, 1<<20), mem)
+
+	dir, err := ioutil.TempDir("", "blockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// memCache disabled so Get exercises diskBlockCache's on-disk cache
+	// file path - the fs driver where this offset/length inconsistency was
+	// originally introduced and later fixed.
+	fs := newDiskBlockCache(&fakeStorage{data: map[string][]byte{"p1": data}}, dir, 0, defaultListCacheDuration, nil, 0)
+	defer fs.Close()
+
+	for _, c := range []BlockCache{mem, tiered, fs} {
+		full, err := c.Get("v1", "p1", 0, -1)
+		if err != nil {
+			t.Fatalf("Get(full) failed: %v", err)
+		}
+		if !bytes.Equal(full, data) {
+			t.Fatalf("Get(full) = %q, want %q", full, data)
+		}
+
+		// repeat the partial read so repeated range requests are checked too;
+		// each is a cache hit, since the full read above already populated
+		// every driver (for fs, its on-disk cache file).
+		for i := 0; i < 2; i++ {
+			partial, err := c.Get("v1", "p1", 10, 5)
+			if err != nil {
+				t.Fatalf("Get(10, 5) failed: %v", err)
+			}
+			if !bytes.Equal(partial, data[10:15]) {
+				t.Fatalf("Get(10, 5) = %q, want %q", partial, data[10:15])
+			}
+		}
+	}
+}
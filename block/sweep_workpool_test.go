@@ -0,0 +1,39 @@
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kopia/kopia/internal/workshare"
+)
+
+// TestSweepDirectory_WithWorkPool verifies that sweepDirectory's verify and
+// evict phases produce the same result whether or not they're parallelized
+// across a workshare.Pool.
+func TestSweepDirectory_WithWorkPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pool := workshare.NewPool(4)
+	defer pool.Close()
+
+	c := newDiskBlockCache(nil, dir, 0, defaultListCacheDuration, nil, 0)
+	c.SetWorkPool(pool)
+	c.SetEvictionLimits(3, 0)
+
+	for i := 0; i < 10; i++ {
+		writeTestCacheFile(t, c, string(rune('a'+i)), 16)
+	}
+
+	if err := c.sweepDirectory(); err != nil {
+		t.Fatalf("sweepDirectory failed: %v", err)
+	}
+
+	if got := countCacheFiles(t, dir); got != 3 {
+		t.Fatalf("expected maxItems=3 to be enforced with a work pool, found %v cache files", got)
+	}
+}
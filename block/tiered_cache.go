@@ -0,0 +1,82 @@
+package block
+
+// tieredBlockCache is the "tiered" BlockCache driver: it composes a small,
+// fast l1 (typically a memoryCacheDriver with no storage.Storage of its own)
+// in front of a larger, slower l2 (typically an fs driver backed by
+// storage.Storage). Reads check l1 first and populate it on an l2 hit; writes
+// go through both tiers.
+type tieredBlockCache struct {
+	l1 BlockCache
+	l2 BlockCache
+}
+
+func newTieredBlockCache(l1, l2 BlockCache) *tieredBlockCache {
+	return &tieredBlockCache{l1: l1, l2: l2}
+}
+
+// Get implements BlockCache. Both tiers are always asked for the full block
+// (offset=0, length=-1) and the result is sliced once here, so that l1 and
+// l2 cache the same bytes under virtualBlockID regardless of which range a
+// given caller happens to request.
+func (c *tieredBlockCache) Get(virtualBlockID, physicalBlockID string, offset, length int64) ([]byte, error) {
+	if b, err := c.l1.Get(virtualBlockID, physicalBlockID, 0, -1); err == nil {
+		return applyOffsetAndLength(b, offset, length)
+	}
+
+	b, err := c.l2.Get(virtualBlockID, physicalBlockID, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	c.l1.Put(virtualBlockID, b)
+
+	return applyOffsetAndLength(b, offset, length)
+}
+
+// Put implements BlockCache.
+func (c *tieredBlockCache) Put(blockID string, data []byte) error {
+	if err := c.l2.Put(blockID, data); err != nil {
+		return err
+	}
+
+	return c.l1.Put(blockID, data)
+}
+
+// Delete implements BlockCache.
+func (c *tieredBlockCache) Delete(key string) error {
+	if err := c.l1.Delete(key); err != nil {
+		return err
+	}
+
+	return c.l2.Delete(key)
+}
+
+// List implements BlockCache.
+func (c *tieredBlockCache) List(full bool) ([]Info, error) {
+	return c.l2.List(full)
+}
+
+// Close implements BlockCache.
+func (c *tieredBlockCache) Close() error {
+	if err := c.l1.Close(); err != nil {
+		return err
+	}
+
+	return c.l2.Close()
+}
+
+// Metrics implements BlockCache, combining hit/miss/eviction counts from
+// both tiers. Compression byte counts only come from whichever tier is
+// fs-backed, since l1 is typically a memoryCacheDriver that doesn't compress.
+func (c *tieredBlockCache) Metrics() CacheMetrics {
+	l1 := c.l1.Metrics()
+	l2 := c.l2.Metrics()
+
+	return CacheMetrics{
+		Hits:              l1.Hits + l2.Hits,
+		Misses:            l1.Misses + l2.Misses,
+		Evictions:         l1.Evictions + l2.Evictions,
+		UncompressedBytes: l1.UncompressedBytes + l2.UncompressedBytes,
+		CompressedBytes:   l1.CompressedBytes + l2.CompressedBytes,
+	}
+}
@@ -0,0 +1,107 @@
+// Package workshare provides a small bounded worker pool that lets
+// recursive or fan-out algorithms opportunistically offload part of their
+// work to other goroutines, falling back to running inline when the pool
+// has no spare capacity.
+package workshare
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Pool is a bounded pool of worker slots shared across possibly many
+// concurrent AsyncGroups. A nil *Pool, or one created with zero workers,
+// behaves as "no pool available": CanShareWork always returns false and
+// callers should run the work inline.
+type Pool struct {
+	semaphore chan struct{}
+	active    int32
+}
+
+// NewPool creates a Pool capable of running up to numWorkers pieces of work
+// concurrently. numWorkers <= 0 creates a pool with no spare capacity, so
+// CanShareWork always returns false.
+func NewPool(numWorkers int) *Pool {
+	p := &Pool{}
+
+	if numWorkers > 0 {
+		p.semaphore = make(chan struct{}, numWorkers)
+	}
+
+	return p
+}
+
+// ActiveWorkers returns the number of goroutines currently executing work
+// dispatched through this pool.
+func (p *Pool) ActiveWorkers() int {
+	if p == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt32(&p.active))
+}
+
+// Close releases resources associated with the pool. It does not wait for
+// in-flight work - callers should Wait() on any outstanding AsyncGroups
+// first.
+func (p *Pool) Close() {
+}
+
+// AsyncGroup tracks a set of work items dispatched to a Pool so that a
+// caller can wait for all of them to complete.
+type AsyncGroup struct {
+	wg sync.WaitGroup
+
+	mu      sync.Mutex
+	results []interface{}
+}
+
+// CanShareWork reports whether pool has a free worker slot right now, and if
+// so reserves it for an immediately-following call to RunAsync. Callers
+// should fall back to running work inline when this returns false.
+func (g *AsyncGroup) CanShareWork(pool *Pool) bool {
+	if pool == nil || pool.semaphore == nil {
+		return false
+	}
+
+	select {
+	case pool.semaphore <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunAsync runs fn(pool, input) on another goroutine, consuming the worker
+// slot reserved by a prior successful call to CanShareWork. The input value
+// (typically a pointer to a request/result struct that fn mutates in place)
+// becomes available from Wait() once fn returns.
+func (g *AsyncGroup) RunAsync(pool *Pool, fn func(w *Pool, input interface{}), input interface{}) {
+	atomic.AddInt32(&pool.active, 1)
+	g.wg.Add(1)
+
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			atomic.AddInt32(&pool.active, -1)
+			<-pool.semaphore
+		}()
+
+		fn(pool, input)
+
+		g.mu.Lock()
+		g.results = append(g.results, input)
+		g.mu.Unlock()
+	}()
+}
+
+// Wait blocks until all work dispatched via RunAsync on this group has
+// completed, and returns the (mutated) input values in completion order.
+func (g *AsyncGroup) Wait() []interface{} {
+	g.wg.Wait()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.results
+}